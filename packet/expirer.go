@@ -0,0 +1,63 @@
+package packet
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// leaseDuration 是扫描过期红包时单个 worker 持有一个红包的租约时长
+// 租约到期后其它 worker 实例可以重新认领，避免某个 worker 卡死导致红包一直得不到退款
+const leaseDuration = 30 * time.Second
+
+// RunExpirer 周期性扫描已过期但尚未退款的红包并执行退款，会一直运行直到 ctx 被取消
+// owner 标识当前 worker 实例，用于租约认领，多个实例可以安全地并发运行
+func RunExpirer(ctx context.Context, db *gorm.DB, interval time.Duration, owner string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expireOnce(db, owner)
+		}
+	}
+}
+
+// zeroExpireAt 是 time.Time 的零值，对应未设置 ExpireAt 的红包
+// 没有设置 ExpireAt 的红包永不过期，扫描时必须排除，否则会被当成很久以前就过期处理
+var zeroExpireAt time.Time
+
+// expireOnce 扫描一批已过期的红包，认领租约后逐个退款
+func expireOnce(db *gorm.DB, owner string) {
+	now := time.Now()
+
+	var packets []Packet
+	if err := db.Where("expire_at > ? AND expire_at < ? AND remain_amount > 0 AND refunded_at IS NULL AND (leased_until IS NULL OR leased_until < ?)", zeroExpireAt, now, now).
+		Find(&packets).Error; err != nil {
+		return
+	}
+
+	for _, p := range packets {
+		if !leasePacket(db, p.ID, owner, now) {
+			// 租约被其它 worker 实例抢先认领
+			continue
+		}
+
+		_ = Refund(db, p.ID)
+	}
+}
+
+// leasePacket 尝试认领 packetID 的租约，成功返回 true
+func leasePacket(db *gorm.DB, packetID int64, owner string, now time.Time) bool {
+	leasedUntil := now.Add(leaseDuration)
+
+	result := db.Model(&Packet{}).
+		Where("id = ? AND (leased_until IS NULL OR leased_until < ?)", packetID, now).
+		Updates(map[string]interface{}{"lease_owner": owner, "leased_until": &leasedUntil})
+
+	return result.Error == nil && result.RowsAffected > 0
+}
@@ -0,0 +1,86 @@
+package packet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/shopspring/decimal"
+
+	"github.com/yiplee/packet-demo/packet/wallet"
+)
+
+func expirerTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.AutoMigrate(&Packet{}, &wallet.Wallet{}, &wallet.FinUserFlow{}).Error; err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return db
+}
+
+func TestExpireOnceIgnoresPacketsWithoutExpireAt(t *testing.T) {
+	db := expirerTestDB(t)
+
+	// 没有设置 ExpireAt 的红包（零值）永不过期，不应该被扫描到并退款
+	live := &Packet{UserID: 1, TotalAmount: decimal.NewFromInt(10), RemainAmount: decimal.NewFromInt(10)}
+	if err := db.Create(live).Error; err != nil {
+		t.Fatalf("create packet: %v", err)
+	}
+
+	expireOnce(db, "worker-1")
+
+	var reloaded Packet
+	if err := db.Where("id = ?", live.ID).First(&reloaded).Error; err != nil {
+		t.Fatalf("find packet: %v", err)
+	}
+	if reloaded.RefundedAt != nil {
+		t.Fatalf("packet without ExpireAt was refunded, want untouched")
+	}
+	if !reloaded.RemainAmount.Equal(decimal.NewFromInt(10)) {
+		t.Fatalf("remain amount = %s, want untouched 10", reloaded.RemainAmount)
+	}
+}
+
+func TestExpireOnceRefundsExpiredPacket(t *testing.T) {
+	db := expirerTestDB(t)
+
+	expired := &Packet{
+		UserID:       1,
+		TotalAmount:  decimal.NewFromInt(10),
+		RemainAmount: decimal.NewFromInt(10),
+		ExpireAt:     time.Now().Add(-time.Hour),
+	}
+	if err := db.Create(expired).Error; err != nil {
+		t.Fatalf("create packet: %v", err)
+	}
+
+	expireOnce(db, "worker-1")
+
+	var reloaded Packet
+	if err := db.Where("id = ?", expired.ID).First(&reloaded).Error; err != nil {
+		t.Fatalf("find packet: %v", err)
+	}
+	if reloaded.RefundedAt == nil {
+		t.Fatalf("expired packet was not refunded")
+	}
+	if !reloaded.RemainAmount.IsZero() {
+		t.Fatalf("remain amount = %s, want 0 after refund", reloaded.RemainAmount)
+	}
+
+	var w wallet.Wallet
+	if err := db.Where("user_id = ?", 1).First(&w).Error; err != nil {
+		t.Fatalf("find wallet: %v", err)
+	}
+	if !w.Balance.Equal(decimal.NewFromInt(10)) {
+		t.Fatalf("sender balance = %s, want 10 after refund", w.Balance)
+	}
+}
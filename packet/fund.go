@@ -0,0 +1,59 @@
+package packet
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
+
+	"github.com/yiplee/packet-demo/packet/wallet"
+)
+
+// CreatePacket 创建红包，并在同一个事务中从发送者钱包扣款
+//
+// 配置了 claimStore 时，还需要在红包对外可见之前把槽位写入 Redis：
+// 否则 Claim 会先走 Redis 快速路径，LPOP 一个还不存在的 slots 列表
+// 返回 redis.Nil，被当成"已抢光"拒掉所有人
+func CreatePacket(ctx context.Context, db *gorm.DB, p *Packet) error {
+	if err := transaction(db, func(tx *gorm.DB) error {
+		if err := tx.Create(p).Error; err != nil {
+			return err
+		}
+
+		return wallet.Debit(tx, p.UserID, wallet.FlowPacketSend, p.ID, p.TotalAmount)
+	}); err != nil {
+		return err
+	}
+
+	if claimStore != nil && p.Mode != Prize {
+		return claimStore.PrepareSlots(ctx, p)
+	}
+
+	return nil
+}
+
+// Refund 将已过期红包剩余的金额退还给发送者，重复调用是安全的
+func Refund(db *gorm.DB, packetID int64) error {
+	return transaction(db, func(tx *gorm.DB) error {
+		var p Packet
+		if err := tx.Where("id = ?", packetID).First(&p).Error; err != nil {
+			return err
+		}
+
+		if p.RefundedAt != nil || p.RemainAmount.IsZero() {
+			return nil
+		}
+
+		if err := wallet.Credit(tx, p.UserID, wallet.FlowPacketRefund, p.ID, p.RemainAmount); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		return tx.Model(&p).Updates(map[string]interface{}{
+			"remain_count":  0,
+			"remain_amount": decimal.Zero,
+			"refunded_at":   &now,
+		}).Error
+	})
+}
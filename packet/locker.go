@@ -0,0 +1,187 @@
+package packet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrBusy 表示在调用方的 ctx 超时前未能获取红包锁
+var ErrBusy = errors.New("packet: busy")
+
+// Locker 为 Claim 的读-改-写临界区提供互斥
+type Locker interface {
+	// Lock 获取 packetID 对应的锁，ctx 超时前未获取到则返回 ErrBusy
+	// 返回的 release 用于释放锁，调用方必须在临界区结束后调用
+	Lock(ctx context.Context, packetID int64) (release func(), err error)
+}
+
+// locker 是当前配置的全局 Locker，为空时 Claim 退回原有的乐观锁重试路径
+var locker Locker
+
+// SetLocker 配置 Claim 使用的分布式锁
+func SetLocker(l Locker) {
+	locker = l
+}
+
+const (
+	lockTTL        = 2 * time.Second
+	lockRenewEvery = lockTTL / 2
+)
+
+// releaseScript 只有锁仍然属于自己（token 匹配）才会删除，避免释放了别人续约后持有的锁
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// RedisLocker 是 Locker 的 Redis 实现
+// 默认通过 SET NX PX 竞争获取、Lua 脚本 CAS 删除释放；
+// 持锁期间由后台 goroutine 定期续期，避免临界区执行时间超过 TTL 导致锁提前释放
+type RedisLocker struct {
+	client *redis.Client
+
+	// Fair 开启后使用 Redis 列表实现的 FIFO 排队，而不是在 thundering herd 下随机竞争
+	Fair bool
+}
+
+// NewRedisLocker 创建一个 RedisLocker
+func NewRedisLocker(client *redis.Client) *RedisLocker {
+	return &RedisLocker{client: client}
+}
+
+func lockKey(packetID int64) string  { return fmt.Sprintf("lock:packet:%d", packetID) }
+func queueKey(packetID int64) string { return fmt.Sprintf("lock:packet:%d:queue", packetID) }
+func wakeKey(queue, token string) string {
+	return fmt.Sprintf("%s:wake:%s", queue, token)
+}
+
+func (l *RedisLocker) Lock(ctx context.Context, packetID int64) (func(), error) {
+	if l.Fair {
+		return l.lockFair(ctx, packetID)
+	}
+
+	return l.lockOnce(ctx, packetID)
+}
+
+// lockOnce 用 SET NX PX 轮询竞争锁，谁先抢到算谁的
+func (l *RedisLocker) lockOnce(ctx context.Context, packetID int64) (func(), error) {
+	token := randomToken()
+	key := lockKey(packetID)
+
+	for {
+		ok, err := l.client.SetNX(ctx, key, token, lockTTL).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return l.renew(key, token), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ErrBusy
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+// lockFair 先排进 FIFO 队列，只有轮到自己时才去获取锁
+// 避免 lockOnce 轮询模式下新来的请求插队抢到锁
+func (l *RedisLocker) lockFair(ctx context.Context, packetID int64) (func(), error) {
+	token := randomToken()
+	queue := queueKey(packetID)
+	key := lockKey(packetID)
+
+	pos, err := l.client.RPush(ctx, queue, token).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if pos > 1 {
+		timeout := lockWaitTimeout(ctx)
+		if timeout <= 0 {
+			l.client.LRem(ctx, queue, 1, token)
+			return nil, ErrBusy
+		}
+
+		if _, err := l.client.BLPop(ctx, timeout, wakeKey(queue, token)).Result(); err != nil {
+			l.client.LRem(ctx, queue, 1, token)
+			return nil, ErrBusy
+		}
+	}
+
+	if err := l.client.Set(ctx, key, token, lockTTL).Err(); err != nil {
+		l.client.LPop(ctx, queue)
+		return nil, err
+	}
+
+	release := l.renew(key, token)
+
+	return func() {
+		release()
+		// 用 context.Background()，临界区结束时调用方的 ctx 很可能已经取消
+		// （客户端断开、请求超时都很常见），用它清队列会让 token 永远留在队列里，
+		// wakeNext 持续唤醒一个没人认领的 wake key，队列永久卡死
+		l.client.LPop(context.Background(), queue)
+		l.wakeNext(queue)
+	}, nil
+}
+
+// wakeNext 唤醒排在队首的等待者，保证获取锁的顺序和到达顺序一致
+func (l *RedisLocker) wakeNext(queue string) {
+	ctx := context.Background()
+
+	next, err := l.client.LIndex(ctx, queue, 0).Result()
+	if err != nil || next == "" {
+		return
+	}
+
+	l.client.RPush(ctx, wakeKey(queue, next), "1")
+}
+
+// renew 在持锁期间每隔 lockRenewEvery 续期一次，返回的函数用于释放锁
+func (l *RedisLocker) renew(key, token string) func() {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(lockRenewEvery)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				l.client.Expire(context.Background(), key, lockTTL)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			releaseScript.Run(context.Background(), l.client, []string{key}, token)
+		})
+	}
+}
+
+func lockWaitTimeout(ctx context.Context) time.Duration {
+	if d, ok := ctx.Deadline(); ok {
+		return time.Until(d)
+	}
+
+	return lockTTL * 5
+}
+
+func randomToken() string {
+	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Int63())
+}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/jinzhu/gorm"
 	"github.com/shopspring/decimal"
+
+	"github.com/yiplee/packet-demo/packet/wallet"
 )
 
 type Mode int
@@ -18,12 +20,17 @@ const (
 	Normal
 	// 手气红包
 	Luck
+	// 奖池红包，按概率抽取奖品而不是分钱
+	Prize
 )
 
 var (
 	// 红包已抢光
 	ErrExhausted = errors.New("packet: exhausted")
 
+	// 红包已过期
+	ErrExpired = errors.New("packet: expired")
+
 	// 单个红包最小金额
 	// 创建红包的时候需要检查平均金额不能小于这个数
 	minimumRecordAmount, _ = decimal.NewFromString("0.01")
@@ -45,6 +52,13 @@ type Packet struct {
 	TotalAmount decimal.Decimal `sql:"type:decimal(10,2)" json:"total_amount,omitempty"`
 	// 剩余金额
 	RemainAmount decimal.Decimal `sql:"type:decimal(10,2)" json:"remain_amount,omitempty"`
+	// ExpireAt 过期时间，过期后不能再被领取，由 RunExpirer 自动退款
+	ExpireAt time.Time `json:"expire_at,omitempty"`
+	// RefundedAt 退款时间，非空表示已经退款，避免重复退款
+	RefundedAt *time.Time `json:"refunded_at,omitempty"`
+	// LeaseOwner / LeasedUntil 用于过期扫描的租约，避免多个 worker 实例重复退款同一个红包
+	LeaseOwner  string     `sql:"size:64" json:"lease_owner,omitempty"`
+	LeasedUntil *time.Time `json:"leased_until,omitempty"`
 }
 
 // 领取红包记录
@@ -57,6 +71,11 @@ type Record struct {
 	PacketID int64 `json:"packet_id,omitempty"`
 	// 抢到的金额
 	Amount decimal.Decimal `sql:"type:decimal(10,2)" json:"amount,omitempty"`
+	// Prize 模式下抽中的奖品，非 Prize 模式为 nil
+	PrizeID *int64 `json:"prize_id,omitempty"`
+	// PrizeSnapshot 保存抽中时奖品的快照（JSON），即使奖品之后被修改或删除
+	// 历史记录展示的仍然是当时的奖品信息
+	PrizeSnapshot string `sql:"type:text" json:"prize_snapshot,omitempty"`
 }
 
 func FindPacket(db *gorm.DB, id int64) (*Packet, error) {
@@ -78,16 +97,63 @@ func FindUserRecord(db *gorm.DB, userID, packetID int64) (*Record, error) {
 }
 
 func Claim(ctx context.Context, db *gorm.DB, packet *Packet, userID int64) (*Record, error) {
+	// 配置了分布式锁时，读-改-写的临界区由锁串行化，
+	// 下面的乐观锁重试自然不会再发生冲突；没有配置 Locker 时作为兜底路径
+	if locker != nil {
+		release, err := locker.Lock(ctx, packet.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		// 调用方传入的 packet 是加锁之前读到的，可能已经过时，持锁后重新读取
+		latest, err := FindPacket(db, packet.ID)
+		if err != nil {
+			return nil, err
+		}
+		packet = latest
+	}
+
 	// 检查剩余个数
 	if packet.RemainCount == 0 {
 		return nil, ErrExhausted
 	}
 
+	// 检查是否已经过期，这个检查必须在 Redis 快速路径之前，
+	// 否则过期红包会绕过下面的数据库方案被继续领取
+	if !packet.ExpireAt.IsZero() && packet.ExpireAt.Before(time.Now()) {
+		return nil, ErrExpired
+	}
+
+	// 优先走 Redis 快速路径，Redis 不可用或 Prize 模式时退回下面的数据库方案
+	// Prize 模式需要按库存加权抽奖，PrepareSlots 预生成的金额槽位并不适用
+	if claimStore != nil && packet.Mode != Prize {
+		amount, ok, err := claimStore.Claim(ctx, packet.ID, userID)
+		switch {
+		case err == nil:
+			if !ok {
+				return nil, ErrExhausted
+			}
+
+			return &Record{UserID: userID, PacketID: packet.ID, Amount: amount}, nil
+		case errors.Is(err, ErrOutboxWrite):
+			// 名额已经在 Redis 里被扣掉了，不能退回下面的数据库方案重新抽一次，
+			// 否则会对同一个用户重复发放；把错误往上抛，由调用方重试
+			return nil, err
+		}
+		// 其它错误视为脚本根本没有执行（比如 Redis 不可用），可以安全回退
+	}
+
 	// 检查是否已经抢过了
 	if r, err := FindUserRecord(db, userID, packet.ID); err == nil {
 		return r, nil
 	}
 
+	// 奖池模式按概率抽奖，不走下面按金额分配的逻辑
+	if packet.Mode == Prize {
+		return claimPrize(ctx, db, packet, userID)
+	}
+
 	r := &Record{
 		UserID:   userID,
 		PacketID: packet.ID,
@@ -126,8 +192,12 @@ func Claim(ctx context.Context, db *gorm.DB, packet *Packet, userID int64) (*Rec
 			return ErrOptimisticLock
 		}
 
-		// packet 更新成功，将记录入库
-		return tx.Create(r).Error
+		// packet 更新成功，将记录入库，并为抢到的用户钱包入账
+		if err := tx.Create(r).Error; err != nil {
+			return err
+		}
+
+		return wallet.Credit(tx, userID, wallet.FlowPacketClaim, r.PacketID, r.Amount)
 	}); err != nil {
 		// 被别人抢了，等待 50ms 继续抢
 		if err == ErrOptimisticLock {
@@ -158,5 +228,9 @@ func transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
 	tx := db.Begin()
 	defer tx.RollbackUnlessCommitted()
 
-	return fn(tx)
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit().Error
 }
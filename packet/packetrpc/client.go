@@ -0,0 +1,60 @@
+package packetrpc
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Client 让其它服务不需要依赖 gorm，通过网络调用就能消费红包能力
+type Client struct {
+	cc *grpc.ClientConn
+}
+
+// NewClient 基于已经建立好的 conn 创建一个 PacketService 客户端
+func NewClient(conn *grpc.ClientConn) *Client {
+	return &Client{cc: conn}
+}
+
+// WithUserID 把 userID 写入 outgoing metadata，服务端的 authInterceptor 据此鉴权
+func WithUserID(ctx context.Context, userID int64) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "user-id", strconv.FormatInt(userID, 10))
+}
+
+// withJSONCodec 让这次调用使用 jsonCodec 而不是 grpc 默认的 proto codec，
+// 必须和 NewServer 里的 grpc.ForceServerCodec(jsonCodec{}) 配对使用
+var withJSONCodec = grpc.CallContentSubtype(jsonCodecName)
+
+func (c *Client) Create(ctx context.Context, req *CreateRequest) (*PacketReply, error) {
+	reply := new(PacketReply)
+	if err := c.cc.Invoke(ctx, "/packetrpc.PacketService/Create", req, reply, withJSONCodec); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *Client) FindPacket(ctx context.Context, req *FindPacketRequest) (*PacketReply, error) {
+	reply := new(PacketReply)
+	if err := c.cc.Invoke(ctx, "/packetrpc.PacketService/FindPacket", req, reply, withJSONCodec); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *Client) Claim(ctx context.Context, req *ClaimRequest) (*RecordReply, error) {
+	reply := new(RecordReply)
+	if err := c.cc.Invoke(ctx, "/packetrpc.PacketService/Claim", req, reply, withJSONCodec); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *Client) FindUserRecord(ctx context.Context, req *FindUserRecordRequest) (*RecordReply, error) {
+	reply := new(RecordReply)
+	if err := c.cc.Invoke(ctx, "/packetrpc.PacketService/FindUserRecord", req, reply, withJSONCodec); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
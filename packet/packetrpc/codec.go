@@ -0,0 +1,35 @@
+package packetrpc
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// jsonCodecName 是这个 codec 在内容协商中使用的名字
+const jsonCodecName = "json"
+
+func init() {
+	// 注册到全局 codec 表，这样客户端可以通过 grpc.CallContentSubtype(jsonCodecName) 选用它
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec 是 encoding.Codec 的 JSON 实现
+//
+// packetrpc 的请求/响应类型是手写的 Go struct，没有实现 proto.Message，
+// 所以不能用 grpc 默认的 proto codec 编解码。这里用 JSON 代替，换来的代价是
+// 不再跟其它基于 packet.proto 生成的 protobuf 客户端线上兼容 —— 要做到真正
+// 跨语言、跨服务兼容，需要跑 protoc-gen-go 生成 proto.Message 实现再用默认 codec。
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return jsonCodecName
+}
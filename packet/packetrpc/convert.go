@@ -0,0 +1,25 @@
+package packetrpc
+
+import "github.com/yiplee/packet-demo/packet"
+
+func packetToReply(p *packet.Packet) *PacketReply {
+	return &PacketReply{
+		ID:           p.ID,
+		UserID:       p.UserID,
+		Message:      p.Message,
+		Mode:         int32(p.Mode),
+		TotalCount:   p.TotalCount,
+		RemainCount:  p.RemainCount,
+		TotalAmount:  p.TotalAmount.String(),
+		RemainAmount: p.RemainAmount.String(),
+	}
+}
+
+func recordToReply(r *packet.Record) *RecordReply {
+	return &RecordReply{
+		ID:       r.ID,
+		UserID:   r.UserID,
+		PacketID: r.PacketID,
+		Amount:   r.Amount.String(),
+	}
+}
@@ -0,0 +1,105 @@
+package packetrpc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authInterceptor 校验请求 metadata 中必须带有合法的 user-id
+func authInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing metadata")
+	}
+
+	values := md.Get("user-id")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing user-id")
+	}
+
+	if _, err := parseUserID(values[0]); err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid user-id")
+	}
+
+	return handler(ctx, req)
+}
+
+func parseUserID(s string) (int64, error) {
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// tracer 用于给每一次抢红包打链路追踪的 span
+var tracer = otel.Tracer("packetrpc")
+
+// tracingInterceptor 为每个请求开启一个 span，Claim 请求额外打上 packet_id 标签
+// 方便按红包排查单次抢红包请求在各个服务间的耗时
+func tracingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, span := tracer.Start(ctx, info.FullMethod)
+	defer span.End()
+
+	if claim, ok := req.(*ClaimRequest); ok {
+		span.SetAttributes(attribute.Int64("packet_id", claim.PacketID))
+	}
+
+	return handler(ctx, req)
+}
+
+// rateLimiter 基于固定时间窗口计数，对每个 user-id 独立限流，避免单个用户的重试风暴打垮服务
+type rateLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	window   time.Duration
+	counters map[string]*rateCounter
+}
+
+type rateCounter struct {
+	count      int
+	windowEnds time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		limit:    20,
+		window:   time.Second,
+		counters: make(map[string]*rateCounter),
+	}
+}
+
+func (l *rateLimiter) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var userID string
+	if values := md.Get("user-id"); len(values) > 0 {
+		userID = values[0]
+	}
+
+	if !l.allow(userID) {
+		return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+
+	return handler(ctx, req)
+}
+
+func (l *rateLimiter) allow(userID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	c, ok := l.counters[userID]
+	if !ok || now.After(c.windowEnds) {
+		c = &rateCounter{windowEnds: now.Add(l.window)}
+		l.counters[userID] = c
+	}
+
+	c.count++
+	return c.count <= l.limit
+}
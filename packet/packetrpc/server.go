@@ -0,0 +1,218 @@
+package packetrpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/yiplee/packet-demo/packet"
+)
+
+// PacketServiceServer 是 packet.proto 中 PacketService 的服务端接口
+type PacketServiceServer interface {
+	Create(context.Context, *CreateRequest) (*PacketReply, error)
+	FindPacket(context.Context, *FindPacketRequest) (*PacketReply, error)
+	Claim(context.Context, *ClaimRequest) (*RecordReply, error)
+	FindUserRecord(context.Context, *FindUserRecordRequest) (*RecordReply, error)
+}
+
+// server 把 PacketServiceServer 接口实现在 packet 包之上
+type server struct {
+	db *gorm.DB
+}
+
+func (s *server) Create(ctx context.Context, req *CreateRequest) (*PacketReply, error) {
+	amount, err := decimal.NewFromString(req.TotalAmount)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	p := &packet.Packet{
+		UserID:       req.UserID,
+		Message:      req.Message,
+		Mode:         packet.Mode(req.Mode),
+		TotalCount:   req.TotalCount,
+		RemainCount:  req.TotalCount,
+		TotalAmount:  amount,
+		RemainAmount: amount,
+	}
+
+	if err := packet.CreatePacket(ctx, s.db, p); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return packetToReply(p), nil
+}
+
+func (s *server) FindPacket(ctx context.Context, req *FindPacketRequest) (*PacketReply, error) {
+	p, err := packet.FindPacket(s.db, req.ID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return packetToReply(p), nil
+}
+
+func (s *server) Claim(ctx context.Context, req *ClaimRequest) (*RecordReply, error) {
+	userID, err := userIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := packet.FindPacket(s.db, req.PacketID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	r, err := packet.Claim(ctx, s.db, p, userID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return recordToReply(r), nil
+}
+
+func (s *server) FindUserRecord(ctx context.Context, req *FindUserRecordRequest) (*RecordReply, error) {
+	userID, err := userIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := packet.FindUserRecord(s.db, userID, req.PacketID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return recordToReply(r), nil
+}
+
+// userIDFromContext 从请求 metadata 中取出经过认证拦截器校验的 user-id
+func userIDFromContext(ctx context.Context) (int64, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, status.Error(codes.Unauthenticated, "missing metadata")
+	}
+
+	values := md.Get("user-id")
+	if len(values) == 0 {
+		return 0, status.Error(codes.Unauthenticated, "missing user-id")
+	}
+
+	return parseUserID(values[0])
+}
+
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, packet.ErrExhausted):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, packet.ErrExpired):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// NewServer 创建一个注册好 PacketService、并装配了认证、限流、链路追踪拦截器的 *grpc.Server
+//
+// 请求/响应类型没有实现 proto.Message，这里强制用 jsonCodec 编解码，
+// 所以客户端也必须通过 packetrpc.NewClient 调用，不能用标准 protobuf 客户端
+func NewServer(db *gorm.DB, opts ...grpc.ServerOption) *grpc.Server {
+	limiter := newRateLimiter()
+
+	chain := grpc.ChainUnaryInterceptor(
+		authInterceptor,
+		limiter.interceptor,
+		tracingInterceptor,
+	)
+
+	opts = append(opts, grpc.ForceServerCodec(jsonCodec{}), chain)
+	s := grpc.NewServer(opts...)
+	RegisterPacketServiceServer(s, &server{db: db})
+
+	return s
+}
+
+// RegisterPacketServiceServer 把 srv 注册为 PacketService 的实现
+func RegisterPacketServiceServer(s *grpc.Server, srv PacketServiceServer) {
+	s.RegisterService(&packetServiceDesc, srv)
+}
+
+var packetServiceDesc = grpc.ServiceDesc{
+	ServiceName: "packetrpc.PacketService",
+	HandlerType: (*PacketServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Create", Handler: createHandler},
+		{MethodName: "FindPacket", Handler: findPacketHandler},
+		{MethodName: "Claim", Handler: claimHandler},
+		{MethodName: "FindUserRecord", Handler: findUserRecordHandler},
+	},
+	Metadata: "packet.proto",
+}
+
+func createHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(CreateRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PacketServiceServer).Create(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/packetrpc.PacketService/Create"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PacketServiceServer).Create(ctx, req.(*CreateRequest))
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+func findPacketHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(FindPacketRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PacketServiceServer).FindPacket(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/packetrpc.PacketService/FindPacket"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PacketServiceServer).FindPacket(ctx, req.(*FindPacketRequest))
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+func claimHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(ClaimRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PacketServiceServer).Claim(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/packetrpc.PacketService/Claim"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PacketServiceServer).Claim(ctx, req.(*ClaimRequest))
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+func findUserRecordHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(FindUserRecordRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PacketServiceServer).FindUserRecord(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/packetrpc.PacketService/FindUserRecord"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PacketServiceServer).FindUserRecord(ctx, req.(*FindUserRecordRequest))
+	}
+	return interceptor(ctx, req, info, handler)
+}
@@ -0,0 +1,41 @@
+package packetrpc
+
+// 下面的类型和 packet.proto 中的 message 一一对应
+
+type PacketReply struct {
+	ID           int64  `json:"id,omitempty"`
+	UserID       int64  `json:"user_id,omitempty"`
+	Message      string `json:"message,omitempty"`
+	Mode         int32  `json:"mode,omitempty"`
+	TotalCount   int64  `json:"total_count,omitempty"`
+	RemainCount  int64  `json:"remain_count,omitempty"`
+	TotalAmount  string `json:"total_amount,omitempty"`
+	RemainAmount string `json:"remain_amount,omitempty"`
+}
+
+type RecordReply struct {
+	ID       int64  `json:"id,omitempty"`
+	UserID   int64  `json:"user_id,omitempty"`
+	PacketID int64  `json:"packet_id,omitempty"`
+	Amount   string `json:"amount,omitempty"`
+}
+
+type CreateRequest struct {
+	UserID      int64  `json:"user_id,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Mode        int32  `json:"mode,omitempty"`
+	TotalCount  int64  `json:"total_count,omitempty"`
+	TotalAmount string `json:"total_amount,omitempty"`
+}
+
+type FindPacketRequest struct {
+	ID int64 `json:"id,omitempty"`
+}
+
+type ClaimRequest struct {
+	PacketID int64 `json:"packet_id,omitempty"`
+}
+
+type FindUserRecordRequest struct {
+	PacketID int64 `json:"packet_id,omitempty"`
+}
@@ -0,0 +1,169 @@
+package packet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
+
+	"github.com/yiplee/packet-demo/packet/wallet"
+)
+
+// PrizeType 奖品类型
+type PrizeType string
+
+const (
+	PrizeCash     PrizeType = "cash"
+	PrizeCoupon   PrizeType = "coupon"
+	PrizePhysical PrizeType = "physical"
+)
+
+// ErrNoPrizeStock 表示没有抽到奖品：奖品都已经抽完，或者 maxPrizeDrawRetries 次抽取的库存扣减都失败了，
+// 只能记一条没有奖品的记录
+var ErrNoPrizeStock = errors.New("packet: no prize stock")
+
+// maxPrizeDrawRetries 一次领取最多抽取的次数（含第一次），库存 CAS 失败时排除该奖品重新抽取
+const maxPrizeDrawRetries = 5
+
+// PrizeOption 奖池红包可抽取的奖品
+// Probability 是未考虑库存耗尽情况下的中奖概率（0-1），抽取时只在还有库存的奖品之间归一化
+type PrizeOption struct {
+	ID       int64     `sql:"PRIMARY_KEY" json:"id,omitempty"`
+	PacketID int64     `json:"packet_id,omitempty"`
+	Name     string    `sql:"size:64" json:"name,omitempty"`
+	Type     PrizeType `sql:"size:32" json:"type,omitempty"`
+	// Value 奖品价值，cash 为金额，其它类型仅作展示
+	Value decimal.Decimal `sql:"type:decimal(10,2)" json:"value,omitempty"`
+	// Stock 剩余库存，抽中后原子扣减
+	Stock int64 `json:"stock,omitempty"`
+	// Probability 中奖概率
+	Probability decimal.Decimal `sql:"type:decimal(5,4)" json:"probability,omitempty"`
+}
+
+// claimPrize 处理 Prize 模式下的一次抽奖
+func claimPrize(ctx context.Context, db *gorm.DB, packet *Packet, userID int64) (*Record, error) {
+	r := &Record{UserID: userID, PacketID: packet.ID}
+
+	if err := transaction(db, func(tx *gorm.DB) error {
+		prize, err := drawAndReserve(tx, packet.ID)
+		if err != nil && err != ErrNoPrizeStock {
+			return err
+		}
+
+		if prize != nil {
+			r.Amount = prize.Value
+			r.PrizeID = &prize.ID
+			r.PrizeSnapshot = prizeSnapshot(prize)
+		}
+
+		// 跟普通模式一样通过剩余个数做乐观锁，避免并发领取超发
+		if tx := tx.Model(packet).Where("id = ? AND remain_count = ?", packet.ID, packet.RemainCount).
+			UpdateColumn("remain_count", packet.RemainCount-1); tx.Error != nil {
+			return tx.Error
+		} else if tx.RowsAffected == 0 {
+			return ErrOptimisticLock
+		}
+
+		if err := tx.Create(r).Error; err != nil {
+			return err
+		}
+
+		// 现金奖品才需要实际打款到钱包，优惠券/实物奖品只记录 Record
+		if prize != nil && prize.Type == PrizeCash {
+			return wallet.Credit(tx, userID, wallet.FlowPacketClaim, r.PacketID, r.Amount)
+		}
+
+		return nil
+	}); err != nil {
+		if err == ErrOptimisticLock {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(50 * time.Millisecond):
+				p, err := FindPacket(db, packet.ID)
+				if err != nil {
+					return nil, err
+				}
+
+				return claimPrize(ctx, db, p, userID)
+			}
+		}
+
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// drawAndReserve 按 Probability 加权随机抽取一个还有库存的奖品，并原子扣减其库存
+// 扣减因为并发失败时会排除这个奖品重新抽取，总共最多抽取 maxPrizeDrawRetries 次
+func drawAndReserve(tx *gorm.DB, packetID int64) (*PrizeOption, error) {
+	var prizes []PrizeOption
+	if err := tx.Where("packet_id = ? AND stock > 0", packetID).Find(&prizes).Error; err != nil {
+		return nil, err
+	}
+
+	excluded := make(map[int64]bool)
+
+	for i := 0; i < maxPrizeDrawRetries; i++ {
+		prize, ok := drawPrize(prizes, excluded)
+		if !ok {
+			return nil, ErrNoPrizeStock
+		}
+
+		result := tx.Model(&PrizeOption{}).Where("id = ? AND stock > 0", prize.ID).UpdateColumn("stock", gorm.Expr("stock - 1"))
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected > 0 {
+			return prize, nil
+		}
+
+		// 库存被别人抢先扣减，排除这个奖品继续抽
+		excluded[prize.ID] = true
+	}
+
+	return nil, ErrNoPrizeStock
+}
+
+// drawPrize 在未被排除且还有库存的奖品中按 Probability 加权随机抽取一个
+func drawPrize(prizes []PrizeOption, excluded map[int64]bool) (*PrizeOption, bool) {
+	total := decimal.Zero
+	candidates := make([]PrizeOption, 0, len(prizes))
+	for _, p := range prizes {
+		if p.Stock <= 0 || excluded[p.ID] {
+			continue
+		}
+		candidates = append(candidates, p)
+		total = total.Add(p.Probability)
+	}
+
+	if len(candidates) == 0 || total.IsZero() {
+		return nil, false
+	}
+
+	random := decimal.NewFromFloat(rand.Float64()).Mul(total)
+	cumulative := decimal.Zero
+	for i := range candidates {
+		cumulative = cumulative.Add(candidates[i].Probability)
+		if random.LessThanOrEqual(cumulative) {
+			return &candidates[i], true
+		}
+	}
+
+	return &candidates[len(candidates)-1], true
+}
+
+// prizeSnapshot 序列化奖品快照，即使奖品之后被修改也能保留历史中奖信息
+func prizeSnapshot(p *PrizeOption) string {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
@@ -0,0 +1,114 @@
+package packet
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/shopspring/decimal"
+
+	"github.com/yiplee/packet-demo/packet/wallet"
+)
+
+func TestDrawPrizeSkipsExhaustedAndExcluded(t *testing.T) {
+	prizes := []PrizeOption{
+		{ID: 1, Stock: 0, Probability: decimal.NewFromFloat(0.9)},
+		{ID: 2, Stock: 5, Probability: decimal.NewFromFloat(0.05)},
+		{ID: 3, Stock: 5, Probability: decimal.NewFromFloat(0.05)},
+	}
+
+	for i := 0; i < 100; i++ {
+		p, ok := drawPrize(prizes, map[int64]bool{3: true})
+		if !ok {
+			t.Fatalf("drawPrize returned ok=false, want a draw from prize 2")
+		}
+		if p.ID != 2 {
+			t.Fatalf("drew prize %d, want 2 (1 is out of stock, 3 is excluded)", p.ID)
+		}
+	}
+}
+
+func TestDrawPrizeNoCandidatesLeft(t *testing.T) {
+	prizes := []PrizeOption{{ID: 1, Stock: 0, Probability: decimal.NewFromFloat(1)}}
+
+	if _, ok := drawPrize(prizes, nil); ok {
+		t.Fatalf("drawPrize should fail when every prize is out of stock")
+	}
+}
+
+func prizeTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.AutoMigrate(&Packet{}, &Record{}, &PrizeOption{}, &wallet.Wallet{}, &wallet.FinUserFlow{}).Error; err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return db
+}
+
+func TestClaimPrizeCashCreditsWallet(t *testing.T) {
+	db := prizeTestDB(t)
+
+	p := &Packet{UserID: 1, Mode: Prize, TotalCount: 1, RemainCount: 1, TotalAmount: decimal.NewFromInt(10), RemainAmount: decimal.NewFromInt(10)}
+	if err := db.Create(p).Error; err != nil {
+		t.Fatalf("create packet: %v", err)
+	}
+
+	prize := &PrizeOption{PacketID: p.ID, Name: "现金红包", Type: PrizeCash, Value: decimal.NewFromInt(10), Stock: 1, Probability: decimal.NewFromInt(1)}
+	if err := db.Create(prize).Error; err != nil {
+		t.Fatalf("create prize: %v", err)
+	}
+
+	r, err := claimPrize(context.Background(), db, p, 2)
+	if err != nil {
+		t.Fatalf("claimPrize: %v", err)
+	}
+
+	if r.PrizeID == nil || *r.PrizeID != prize.ID {
+		t.Fatalf("record PrizeID = %v, want %d", r.PrizeID, prize.ID)
+	}
+
+	var w wallet.Wallet
+	if err := db.Where("user_id = ?", 2).First(&w).Error; err != nil {
+		t.Fatalf("find wallet: %v", err)
+	}
+	if !w.Balance.Equal(decimal.NewFromInt(10)) {
+		t.Fatalf("wallet balance = %s, want 10", w.Balance)
+	}
+
+	var remaining PrizeOption
+	if err := db.Where("id = ?", prize.ID).First(&remaining).Error; err != nil {
+		t.Fatalf("find prize: %v", err)
+	}
+	if remaining.Stock != 0 {
+		t.Fatalf("prize stock = %d, want 0 after being claimed", remaining.Stock)
+	}
+}
+
+func TestClaimPrizeNoStockLeavesEmptyRecord(t *testing.T) {
+	db := prizeTestDB(t)
+
+	p := &Packet{UserID: 1, Mode: Prize, TotalCount: 1, RemainCount: 1, TotalAmount: decimal.NewFromInt(10), RemainAmount: decimal.NewFromInt(10)}
+	if err := db.Create(p).Error; err != nil {
+		t.Fatalf("create packet: %v", err)
+	}
+
+	r, err := claimPrize(context.Background(), db, p, 2)
+	if err != nil {
+		t.Fatalf("claimPrize: %v", err)
+	}
+
+	if r.PrizeID != nil {
+		t.Fatalf("record PrizeID = %v, want nil when there is no prize stock", r.PrizeID)
+	}
+	if !r.Amount.IsZero() {
+		t.Fatalf("record Amount = %s, want 0 when there is no prize stock", r.Amount)
+	}
+}
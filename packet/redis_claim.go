@@ -0,0 +1,236 @@
+package packet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
+
+	"github.com/yiplee/packet-demo/packet/wallet"
+)
+
+// claimScript 原子地从 Redis 中领取一个名额的金额
+//
+// KEYS[1] = packet:{id}:slots   预生成的金额列表
+// KEYS[2] = packet:{id}:claimed 已领取用户集合，用于去重
+// KEYS[3] = packet:{id}:records 用户 -> 金额，已领取用户重复请求时直接返回
+// ARGV[1] = userID
+var claimScript = redis.NewScript(`
+if redis.call("SISMEMBER", KEYS[2], ARGV[1]) == 1 then
+	return redis.call("HGET", KEYS[3], ARGV[1])
+end
+
+local amount = redis.call("LPOP", KEYS[1])
+if not amount then
+	return false
+end
+
+redis.call("SADD", KEYS[2], ARGV[1])
+redis.call("HSET", KEYS[3], ARGV[1], amount)
+return amount
+`)
+
+// claimStore 是可选的 Redis 快速领取路径，通过 SetClaimStore 配置
+// 为空时 Claim 退回原有的乐观锁 + MySQL 方案
+var claimStore *RedisStore
+
+// SetClaimStore 配置 Claim 使用的 Redis 快速领取路径
+func SetClaimStore(s *RedisStore) {
+	claimStore = s
+}
+
+// RedisStore 把 Claim 的读-改-写变成 Redis 上的一次 O(1) 原子操作
+//
+// 红包创建时按 Mode 预生成每个名额的金额并打乱顺序，推入 packet:{id}:slots，
+// 领取时通过 claimScript 原子出队，彻底消除了乐观锁下的重试竞争。
+// 领取成功后会同步写入一条 ClaimOutbox 记录，Record、Packet 和钱包在 MySQL 中
+// 的落库由 RunOutboxWorker 异步完成并不断重试，即使进程崩溃也不会丢失这次领取。
+type RedisStore struct {
+	client *redis.Client
+	db     *gorm.DB
+}
+
+// NewRedisStore 创建一个 RedisStore，db 用于写入 ClaimOutbox
+func NewRedisStore(client *redis.Client, db *gorm.DB) *RedisStore {
+	return &RedisStore{client: client, db: db}
+}
+
+// ClaimOutbox 记录一次通过 Redis 领取、但还没有落盘到 Record/Packet/钱包的领取结果
+// RunOutboxWorker 会不断重试直到成功处理为止
+type ClaimOutbox struct {
+	ID          int64           `sql:"PRIMARY_KEY" json:"id,omitempty"`
+	CreatedAt   time.Time       `json:"created_at,omitempty"`
+	PacketID    int64           `json:"packet_id,omitempty"`
+	UserID      int64           `json:"user_id,omitempty"`
+	Amount      decimal.Decimal `sql:"type:decimal(10,2)" json:"amount,omitempty"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+}
+
+func slotsKey(packetID int64) string   { return fmt.Sprintf("packet:%d:slots", packetID) }
+func claimedKey(packetID int64) string { return fmt.Sprintf("packet:%d:claimed", packetID) }
+func recordsKey(packetID int64) string { return fmt.Sprintf("packet:%d:records", packetID) }
+
+// ErrUnsupportedMode 表示这个 Mode 没有对应的 Redis 快速路径
+// Prize 模式需要按库存加权抽奖，不是简单的预生成金额槽位，走 Claim 里的数据库方案
+var ErrUnsupportedMode = errors.New("packet: mode unsupported by redis claim store")
+
+// ErrOutboxWrite 表示 claimScript 已经原子地扣掉了一个名额（用户已经被
+// SADD/HSET 进 Redis），但写入 ClaimOutbox 失败。这种情况下名额已经不可能
+// 再分给别人了，调用方必须重试或者把错误往上抛，绝不能当成"Redis 不可用"
+// 退回数据库方案重新抽一次，否则会对同一个用户重复发放
+var ErrOutboxWrite = errors.New("packet: claim outbox write failed after slot consumed")
+
+// PrepareSlots 在红包创建时预生成每个名额的金额并打乱顺序，写入 Redis
+func (s *RedisStore) PrepareSlots(ctx context.Context, packet *Packet) error {
+	if packet.Mode == Prize {
+		return ErrUnsupportedMode
+	}
+
+	amounts := generateAmounts(packet)
+
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, slotsKey(packet.ID), claimedKey(packet.ID), recordsKey(packet.ID))
+	for _, amount := range amounts {
+		pipe.RPush(ctx, slotsKey(packet.ID), amount.String())
+	}
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+// Claim 尝试通过 Redis 原子领取一个名额
+// ok 为 false 表示红包已经被抢光
+func (s *RedisStore) Claim(ctx context.Context, packetID, userID int64) (amount decimal.Decimal, ok bool, err error) {
+	res, err := claimScript.Run(ctx, s.client, []string{slotsKey(packetID), claimedKey(packetID), recordsKey(packetID)}, userID).Result()
+	if err == redis.Nil {
+		return decimal.Zero, false, nil
+	}
+	if err != nil {
+		return decimal.Zero, false, err
+	}
+
+	str, ok := res.(string)
+	if !ok {
+		return decimal.Zero, false, nil
+	}
+
+	amount, err = decimal.NewFromString(str)
+	if err != nil {
+		return decimal.Zero, false, err
+	}
+
+	// 同步写入 outbox，保证即使进程在这里之后崩溃，这次领取也不会丢失
+	// 名额此时已经在 Redis 里被扣掉了，这一步失败不能再被当作"脚本没跑过"处理
+	outbox := &ClaimOutbox{PacketID: packetID, UserID: userID, Amount: amount}
+	if err := s.db.Create(outbox).Error; err != nil {
+		return decimal.Zero, false, fmt.Errorf("%w: %v", ErrOutboxWrite, err)
+	}
+
+	return amount, true, nil
+}
+
+// generateAmounts 为红包的每一个名额预生成金额
+// Normal 模式平均分配；Luck 模式沿用"双均值"随机算法：
+// 每个名额在 [最小值, 剩余平均值 * 2] 之间随机选取，保证期望公平
+func generateAmounts(packet *Packet) []decimal.Decimal {
+	amounts := make([]decimal.Decimal, 0, packet.TotalCount)
+
+	remainAmount := packet.TotalAmount
+	remainCount := packet.TotalCount
+
+	for remainCount > 1 {
+		var amount decimal.Decimal
+
+		if packet.Mode == Normal {
+			amount = remainAmount.Div(decimal.NewFromInt(remainCount))
+		} else {
+			min := minimumRecordAmount
+			max := remainAmount.Sub(decimal.NewFromInt(remainCount - 1).Mul(min))
+			if avg := remainAmount.Div(decimal.NewFromInt(remainCount)); avg.Add(avg).LessThan(max) {
+				max = avg.Add(avg)
+			}
+
+			random := decimal.NewFromFloat(rand.Float64())
+			amount = max.Sub(min).Mul(random).Add(min).Truncate(min.Exponent())
+		}
+
+		amounts = append(amounts, amount)
+		remainAmount = remainAmount.Sub(amount)
+		remainCount--
+	}
+	amounts = append(amounts, remainAmount)
+
+	rand.Shuffle(len(amounts), func(i, j int) {
+		amounts[i], amounts[j] = amounts[j], amounts[i]
+	})
+
+	return amounts
+}
+
+// outboxBatchSize 是每一轮 RunOutboxWorker 最多处理的条目数
+const outboxBatchSize = 100
+
+// RunOutboxWorker 周期性扫描还未处理的 ClaimOutbox，把 Redis 快速路径领取的结果
+// 写入 Record、Packet 和钱包。单条处理失败不会阻塞其它条目，下一轮会重试
+func RunOutboxWorker(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			processOutboxOnce(db)
+		}
+	}
+}
+
+func processOutboxOnce(db *gorm.DB) {
+	var entries []ClaimOutbox
+	if err := db.Where("processed_at IS NULL").Limit(outboxBatchSize).Find(&entries).Error; err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		_ = applyOutboxEntry(db, e)
+	}
+}
+
+// applyOutboxEntry 把一条 outbox 记录落盘成 Record，扣减 Packet 剩余个数/金额，
+// 并为领取人钱包入账，全部在同一个事务里完成。重试是安全的：
+// 如果 Record 已经写入过（上一轮部分失败），这里会直接跳到标记已处理
+func applyOutboxEntry(db *gorm.DB, e ClaimOutbox) error {
+	return transaction(db, func(tx *gorm.DB) error {
+		if _, err := FindUserRecord(tx, e.UserID, e.PacketID); err == nil {
+			return markOutboxProcessed(tx, e.ID)
+		}
+
+		r := &Record{UserID: e.UserID, PacketID: e.PacketID, Amount: e.Amount}
+		if err := tx.Create(r).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&Packet{ID: e.PacketID}).
+			UpdateColumn("remain_count", gorm.Expr("remain_count - 1")).
+			UpdateColumn("remain_amount", gorm.Expr("remain_amount - ?", e.Amount)).Error; err != nil {
+			return err
+		}
+
+		if err := wallet.Credit(tx, e.UserID, wallet.FlowPacketClaim, e.PacketID, e.Amount); err != nil {
+			return err
+		}
+
+		return markOutboxProcessed(tx, e.ID)
+	})
+}
+
+func markOutboxProcessed(tx *gorm.DB, id int64) error {
+	now := time.Now()
+	return tx.Model(&ClaimOutbox{ID: id}).UpdateColumn("processed_at", &now).Error
+}
@@ -0,0 +1,116 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/shopspring/decimal"
+)
+
+// 资金流水类型
+type FlowType string
+
+const (
+	// FlowPacketSend 发红包扣款
+	FlowPacketSend FlowType = "packet_send"
+	// FlowPacketClaim 抢到红包入账
+	FlowPacketClaim FlowType = "packet_claim"
+	// FlowPacketRefund 红包过期退款
+	FlowPacketRefund FlowType = "packet_refund"
+)
+
+// ErrInsufficientBalance 余额不足，无法扣款
+var ErrInsufficientBalance = errors.New("wallet: insufficient balance")
+
+// Wallet 用户钱包，记录余额
+type Wallet struct {
+	UserID    int64           `sql:"PRIMARY_KEY" json:"user_id,omitempty"`
+	Balance   decimal.Decimal `sql:"type:decimal(10,2)" json:"balance,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at,omitempty"`
+}
+
+// FinUserFlow 资金流水，记录每一笔钱包变动，用于对账和审计
+type FinUserFlow struct {
+	ID        int64     `sql:"PRIMARY_KEY" json:"id,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	UserID    int64     `json:"user_id,omitempty"`
+	Type      FlowType  `sql:"size:32" json:"type,omitempty"`
+	// Amount 为正表示入账，为负表示扣款
+	Amount       decimal.Decimal `sql:"type:decimal(10,2)" json:"amount,omitempty"`
+	BalanceAfter decimal.Decimal `sql:"type:decimal(10,2)" json:"balance_after,omitempty"`
+	// RefID 指向触发这笔流水的红包或领取记录
+	RefID int64 `json:"ref_id,omitempty"`
+	// Idempotency 由 user_id + type + ref_id 组合而成
+	// 加唯一索引，保证重试和重放不会重复入账
+	Idempotency string `sql:"size:128;unique_index" json:"idempotency,omitempty"`
+}
+
+func idempotencyKey(userID int64, typ FlowType, refID int64) string {
+	return fmt.Sprintf("%d:%s:%d", userID, typ, refID)
+}
+
+// Credit 在事务 tx 中为 userID 增加 amount 并写入一条流水
+func Credit(tx *gorm.DB, userID int64, typ FlowType, refID int64, amount decimal.Decimal) error {
+	return mutate(tx, userID, typ, refID, amount)
+}
+
+// Debit 在事务 tx 中为 userID 扣减 amount 并写入一条流水
+func Debit(tx *gorm.DB, userID int64, typ FlowType, refID int64, amount decimal.Decimal) error {
+	return mutate(tx, userID, typ, refID, amount.Neg())
+}
+
+// mutate 以 (user_id, type, ref_id) 为幂等键变更余额
+// 已经存在同样的流水时直接返回，保证重试和重放不会重复扣款或入账
+func mutate(tx *gorm.DB, userID int64, typ FlowType, refID int64, delta decimal.Decimal) error {
+	key := idempotencyKey(userID, typ, refID)
+
+	switch err := tx.Where("idempotency = ?", key).First(&FinUserFlow{}).Error; err {
+	case nil:
+		return nil
+	case gorm.ErrRecordNotFound:
+	default:
+		return err
+	}
+
+	// 用 FOR UPDATE 锁住这一行，避免同一个 userID 的并发变更读到同一个起始余额，
+	// 导致其中一次更新被覆盖丢失（lost update）。sqlite 整个库只有一个写者，
+	// 且不支持 FOR UPDATE 语法，不需要也不能加这个查询选项
+	walletQuery := tx.Where("user_id = ?", userID)
+	if tx.Dialect().GetName() != "sqlite3" {
+		walletQuery = walletQuery.Set("gorm:query_option", "FOR UPDATE")
+	}
+
+	var w Wallet
+	if err := walletQuery.First(&w).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		w = Wallet{UserID: userID}
+		if err := tx.Create(&w).Error; err != nil {
+			return err
+		}
+	}
+
+	balance := w.Balance.Add(delta)
+	if balance.IsNegative() {
+		return ErrInsufficientBalance
+	}
+
+	if tx := tx.Model(&w).UpdateColumn("balance", balance); tx.Error != nil {
+		return tx.Error
+	}
+
+	flow := &FinUserFlow{
+		UserID:       userID,
+		Type:         typ,
+		Amount:       delta,
+		BalanceAfter: balance,
+		RefID:        refID,
+		Idempotency:  key,
+	}
+
+	return tx.Create(flow).Error
+}
@@ -0,0 +1,81 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/shopspring/decimal"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.AutoMigrate(&Wallet{}, &FinUserFlow{}).Error; err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return db
+}
+
+func TestCreditDebitRoundTrip(t *testing.T) {
+	db := testDB(t)
+
+	if err := Credit(db, 1, FlowPacketClaim, 100, decimal.RequireFromString("5.00")); err != nil {
+		t.Fatalf("credit: %v", err)
+	}
+	if err := Debit(db, 1, FlowPacketSend, 200, decimal.RequireFromString("2.00")); err != nil {
+		t.Fatalf("debit: %v", err)
+	}
+
+	var w Wallet
+	if err := db.Where("user_id = ?", 1).First(&w).Error; err != nil {
+		t.Fatalf("find wallet: %v", err)
+	}
+
+	if !w.Balance.Equal(decimal.RequireFromString("3.00")) {
+		t.Fatalf("balance = %s, want 3.00", w.Balance)
+	}
+}
+
+func TestMutateIsIdempotent(t *testing.T) {
+	db := testDB(t)
+
+	amount := decimal.RequireFromString("10.00")
+	for i := 0; i < 3; i++ {
+		if err := Credit(db, 1, FlowPacketClaim, 100, amount); err != nil {
+			t.Fatalf("credit #%d: %v", i, err)
+		}
+	}
+
+	var w Wallet
+	if err := db.Where("user_id = ?", 1).First(&w).Error; err != nil {
+		t.Fatalf("find wallet: %v", err)
+	}
+	if !w.Balance.Equal(amount) {
+		t.Fatalf("balance = %s, want %s (retries must not double-credit)", w.Balance, amount)
+	}
+
+	var count int
+	if err := db.Model(&FinUserFlow{}).Where("user_id = ?", 1).Count(&count).Error; err != nil {
+		t.Fatalf("count flows: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("flow count = %d, want 1", count)
+	}
+}
+
+func TestDebitInsufficientBalance(t *testing.T) {
+	db := testDB(t)
+
+	err := Debit(db, 1, FlowPacketSend, 100, decimal.RequireFromString("1.00"))
+	if err != ErrInsufficientBalance {
+		t.Fatalf("err = %v, want ErrInsufficientBalance", err)
+	}
+}